@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus collectors for the /metrics endpoint. Registered once at
+// package init like any other client_golang collector.
+var (
+	scansTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sploit_scans_total",
+		Help: "Number of module scan runs triggered, by module and service.",
+	}, []string{"module", "service"})
+
+	scanDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sploit_scan_duration_seconds",
+		Help: "Duration of a module scan run across all matching host/ports.",
+	}, []string{"module", "service"})
+
+	scanTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sploit_scan_timeouts_total",
+		Help: "Number of module scan runs that hit their timeout and had their console destroyed.",
+	}, []string{"module", "service"})
+
+	vulnsKnown = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sploit_vulns_known",
+		Help: "Number of vulnerabilities currently recorded in the database.",
+	})
+
+	msfUpdateLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sploit_msf_update_last_success_timestamp",
+		Help: "Unix timestamp of the last successful msfupdate.",
+	})
+
+	consoleErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sploit_console_errors_total",
+		Help: "Number of MSF console API calls that returned an error.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(scansTotal, scanDuration, scanTimeoutsTotal, vulnsKnown, msfUpdateLastSuccess, consoleErrorsTotal)
+}
+
+// requireAuth protects a JSON API handler with the webserver's HTTP Digest
+// auth, same as rootHandler, while also accepting the configured API token
+// as a Bearer token for programmatic clients that can't easily do digest
+// auth.
+func (daemon *Daemon) requireAuth(authenticator *auth.DigestAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if token := bearerToken(request); token != "" {
+			if daemon.cfg.Sploit.APIToken != "" && token == daemon.cfg.Sploit.APIToken {
+				next(writer, request)
+				return
+			}
+			http.Error(writer, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		auth.JustCheck(authenticator, next)(writer, request)
+	}
+}
+
+func bearerToken(request *http.Request) string {
+	header := request.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(header, "Bearer ")
+}
+
+func writeJSON(writer http.ResponseWriter, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.WithError(err).Error("Error encoding JSON response")
+	}
+}
+
+func (daemon *Daemon) apiHostsHandler(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, daemon.Hosts)
+}
+
+func (daemon *Daemon) apiServicesHandler(writer http.ResponseWriter, request *http.Request) {
+	if daemon.Services == nil {
+		writeJSON(writer, []service{})
+		return
+	}
+	writeJSON(writer, *daemon.Services)
+}
+
+func (daemon *Daemon) apiVulnsHandler(writer http.ResponseWriter, request *http.Request) {
+	vulns, err := daemon.selectVulns()
+	if err != nil {
+		daemon.reportError(nil, fmt.Sprintf("error selecting vulns from the database: %v", err))
+		http.Error(writer, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	query := request.URL.Query()
+	address := query.Get("address")
+	var since time.Time
+	if raw := query.Get("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("invalid since parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	filtered := make([]vulnerability, 0, len(*vulns))
+	for _, vuln := range *vulns {
+		if address != "" && vuln.Address != address {
+			continue
+		}
+		if !since.IsZero() && vuln.CreatedAt.Before(since) {
+			continue
+		}
+		filtered = append(filtered, vuln)
+	}
+
+	page, perPage := paginationParams(query)
+	start := (page - 1) * perPage
+	if start > len(filtered) {
+		start = len(filtered)
+	}
+	end := start + perPage
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+
+	writeJSON(writer, struct {
+		Total int             `json:"total"`
+		Page  int             `json:"page"`
+		Vulns []vulnerability `json:"vulns"`
+	}{Total: len(filtered), Page: page, Vulns: filtered[start:end]})
+}
+
+func paginationParams(query url.Values) (page, perPage int) {
+	page = 1
+	if raw := query.Get("page"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage = 50
+	if raw := query.Get("per_page"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			perPage = parsed
+		}
+	}
+	return page, perPage
+}
+
+// scanStatus describes one configured module for GET /api/v1/scans.
+type scanStatus struct {
+	Module   string     `json:"module"`
+	Service  string     `json:"service"`
+	CronSpec string     `json:"cron_spec"`
+	Running  bool       `json:"running"`
+	Next     *time.Time `json:"next_run,omitempty"`
+	Prev     *time.Time `json:"prev_run,omitempty"`
+}
+
+func (daemon *Daemon) apiScansHandler(writer http.ResponseWriter, request *http.Request) {
+	if daemon.Services == nil {
+		writeJSON(writer, []scanStatus{})
+		return
+	}
+	statuses := []scanStatus{}
+	for _, svc := range *daemon.Services {
+		for _, mod := range svc.Modules {
+			status := scanStatus{Module: mod.Name, Service: svc.Name, CronSpec: mod.CronSpec, Running: mod.Running}
+			daemon.scanEntriesMu.RLock()
+			entryID, ok := daemon.scanEntries[mod.Name]
+			daemon.scanEntriesMu.RUnlock()
+			if ok {
+				entry := daemon.cron.Entry(entryID)
+				if !entry.Next.IsZero() {
+					next := entry.Next
+					status.Next = &next
+				}
+				if !entry.Prev.IsZero() {
+					prev := entry.Prev
+					status.Prev = &prev
+				}
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	writeJSON(writer, statuses)
+}
+
+// apiScanRunHandler handles POST /api/v1/scans/{module}/run, triggering an
+// on-demand run of a module outside its normal cron schedule.
+func (daemon *Daemon) apiScanRunHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.TrimPrefix(request.URL.Path, "/api/v1/scans/")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "run" {
+		http.NotFound(writer, request)
+		return
+	}
+	moduleName := parts[0]
+
+	serviceName, mod := daemon.findModule(moduleName)
+	if mod == nil {
+		http.Error(writer, fmt.Sprintf("module %q not found", moduleName), http.StatusNotFound)
+		return
+	}
+	if mod.Running {
+		http.Error(writer, fmt.Sprintf("module %q is already running", moduleName), http.StatusConflict)
+		return
+	}
+
+	daemon.waitGroup.Add(1)
+	go func() {
+		defer daemon.waitGroup.Done()
+		daemon.runModuleAgainstEachHostPort(daemon.ctx, serviceName, mod)
+	}()
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(writer).Encode(map[string]string{"module": moduleName, "service": serviceName, "status": "triggered"})
+}
+
+// findModule looks up a module by name, returning its service name and a
+// pointer directly into daemon.Services so callers can read or set Running.
+func (daemon *Daemon) findModule(name string) (string, *module) {
+	if daemon.Services == nil {
+		return "", nil
+	}
+	for i := range *daemon.Services {
+		svc := &(*daemon.Services)[i]
+		for j := range svc.Modules {
+			if svc.Modules[j].Name == name {
+				return svc.Name, &svc.Modules[j]
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// consoleReadBackoffMin is the wait before the first ConsoleRead poll
+	// after a ConsoleWrite, and the starting point for backoff while a
+	// console reports Busy.
+	consoleReadBackoffMin = 100 * time.Millisecond
+	// consoleReadBackoffMax caps how long readUntilIdle will wait between
+	// polls, so a busy console is still polled a few times a minute.
+	consoleReadBackoffMax = 5 * time.Second
+
+	// consoleOutputBufferSize bounds the console output captured per
+	// createConsoleAndRun call. Some commands (e.g. updateMsf's module
+	// search) can produce output that would otherwise grow a bytes.Buffer
+	// without limit; only the most recent bytes are kept, which is enough
+	// for the trailing matches callers actually look for.
+	consoleOutputBufferSize = 256 * 1024
+)
+
+// ConsoleTimeoutError is returned by createConsoleAndRun when ctx is
+// cancelled or its deadline elapses while waiting on a console, so callers
+// can tell a wedged module apart from an ordinary MSF API error and record
+// it distinctly in metrics and notifications.
+type ConsoleTimeoutError struct {
+	ConsoleID string
+	Err       error
+}
+
+func (e *ConsoleTimeoutError) Error() string {
+	return fmt.Sprintf("console %s timed out: %v", e.ConsoleID, e.Err)
+}
+
+func (e *ConsoleTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// ringBuffer is a bounded, append-only byte buffer that keeps only
+// the most recently written max bytes, discarding the oldest data once
+// full. It backs the console output captured by createConsoleAndRun so a
+// hung or chatty module can't grow memory without bound.
+type ringBuffer struct {
+	max int
+	buf []byte
+}
+
+func newRingBuffer(max int) *ringBuffer {
+	return &ringBuffer{max: max}
+}
+
+func (r *ringBuffer) WriteString(s string) {
+	r.buf = append(r.buf, s...)
+	if len(r.buf) > r.max {
+		r.buf = r.buf[len(r.buf)-r.max:]
+	}
+}
+
+func (r *ringBuffer) String() string {
+	return string(r.buf)
+}
+
+// readUntilIdle polls ConsoleRead until the console reports it's no longer
+// Busy, appending each chunk of output to both the structured log and
+// output. It waits consoleReadBackoffMin before the first poll and backs
+// off exponentially up to consoleReadBackoffMax between subsequent polls
+// while the console stays busy, rather than sleeping a fixed amount every
+// time. If ctx is cancelled or its deadline elapses first, it returns a
+// *ConsoleTimeoutError without destroying the console; the caller is
+// responsible for that.
+func (daemon *Daemon) readUntilIdle(ctx context.Context, consoleID string, output *ringBuffer) error {
+	backoff := consoleReadBackoffMin
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return &ConsoleTimeoutError{ConsoleID: consoleID, Err: ctx.Err()}
+		}
+
+		response, err := daemon.API.ConsoleRead(consoleID)
+		if err != nil {
+			consoleErrorsTotal.Inc()
+			daemon.reportError(logrus.Fields{"operation": "createConsoleAndRun", "console_id": consoleID}, fmt.Sprintf("error with ConsoleRead: %v", err))
+			return err
+		}
+		if response.Data != "" {
+			daemon.Logger.WithFields(logrus.Fields{"console_id": consoleID, "output": response.Data}).Debug("Read console output")
+			output.WriteString(response.Data)
+		}
+		if !response.Busy {
+			return nil
+		}
+
+		backoff *= 2
+		if backoff > consoleReadBackoffMax {
+			backoff = consoleReadBackoffMax
+		}
+		daemon.Logger.WithFields(logrus.Fields{"console_id": consoleID, "next_poll": backoff}).Debug("Console is still busy, backing off")
+	}
+}
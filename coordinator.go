@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// Coordinator lets multiple sploit instances pointed at the same MSF/
+// Postgres coordinate over a shared backend, so cron firing on every node
+// doesn't duplicate scans or status/error emails. When no backend is
+// configured, buildCoordinator falls back to noopCoordinator, which
+// preserves today's single-node behavior.
+type Coordinator interface {
+	// TryLock attempts to acquire a session-bound lock for key. ok is
+	// false (with a nil error) if another node already holds it; the
+	// caller should skip the locked work rather than race it. On success
+	// the returned unlock must be called once the work is done.
+	TryLock(ctx context.Context, key string) (unlock func(), ok bool, err error)
+	// IsLeader reports whether this node currently holds the fleet-wide
+	// leader election, used to pick a single node to send status/error
+	// notifications.
+	IsLeader(ctx context.Context) (bool, error)
+}
+
+// coordinatorConfig is the `coordinator:` block of sploit.yml; only the
+// block matching Type is read. An empty Type disables coordination.
+type coordinatorConfig struct {
+	Type   string       `yaml:"type"`
+	Consul consulConfig `yaml:"consul"`
+	Etcd   etcdConfig   `yaml:"etcd"`
+}
+
+// buildCoordinator constructs the configured Coordinator backend. An
+// unrecognized type, or a backend that fails to initialize, is logged and
+// falls back to single-node mode rather than failing startup.
+func (daemon *Daemon) buildCoordinator() Coordinator {
+	switch strings.ToLower(daemon.cfg.Coordinator.Type) {
+	case "":
+		return noopCoordinator{}
+	case "consul":
+		coordinator, err := NewConsulCoordinator(daemon.cfg.Coordinator.Consul)
+		if err != nil {
+			daemon.Logger.WithError(err).Error("Error building consul coordinator, falling back to single-node mode")
+			return noopCoordinator{}
+		}
+		return coordinator
+	case "etcd":
+		coordinator, err := NewEtcdCoordinator(daemon.cfg.Coordinator.Etcd)
+		if err != nil {
+			daemon.Logger.WithError(err).Error("Error building etcd coordinator, falling back to single-node mode")
+			return noopCoordinator{}
+		}
+		return coordinator
+	default:
+		daemon.Logger.WithField("type", daemon.cfg.Coordinator.Type).Warn("Unrecognized coordinator type in sploit.yml, falling back to single-node mode")
+		return noopCoordinator{}
+	}
+}
+
+// noopCoordinator is used when no Coordinator is configured: every lock is
+// granted immediately and this node is always the leader, matching the
+// single-instance behavior sploit had before coordination existed.
+type noopCoordinator struct{}
+
+func (noopCoordinator) TryLock(ctx context.Context, key string) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (noopCoordinator) IsLeader(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// consulConfig configures the consul Coordinator backend.
+type consulConfig struct {
+	Address    string `yaml:"address"`
+	SessionTTL string `yaml:"session_ttl"`
+}
+
+// ConsulCoordinator coordinates over a shared consul agent, using a
+// session-bound KV key per lock and a well-known KV key for leader
+// election.
+type ConsulCoordinator struct {
+	client     *consulapi.Client
+	sessionTTL string
+
+	mu          sync.Mutex
+	sessionID   string
+	renewDoneCh chan struct{}
+}
+
+func NewConsulCoordinator(cfg consulConfig) (*ConsulCoordinator, error) {
+	clientCfg := consulapi.DefaultConfig()
+	if cfg.Address != "" {
+		clientCfg.Address = cfg.Address
+	}
+	client, err := consulapi.NewClient(clientCfg)
+	if err != nil {
+		return nil, fmt.Errorf("building consul client: %w", err)
+	}
+	ttl := cfg.SessionTTL
+	if ttl == "" {
+		ttl = "30s"
+	}
+	return &ConsulCoordinator{client: client, sessionTTL: ttl}, nil
+}
+
+// session lazily creates the consul session locks are acquired against,
+// reusing it across calls, and keeps it alive with RenewPeriodic in the
+// background. Without renewal, consul invalidates the session roughly
+// 2xTTL after creation and every lock/leader acquire would fail forever.
+func (c *ConsulCoordinator) session() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessionID != "" {
+		return c.sessionID, nil
+	}
+	sessionID, _, err := c.client.Session().Create(&consulapi.SessionEntry{
+		TTL:      c.sessionTTL,
+		Behavior: consulapi.SessionBehaviorRelease,
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating consul session: %w", err)
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		if err := c.client.Session().RenewPeriodic(c.sessionTTL, sessionID, nil, doneCh); err != nil {
+			log.WithField("session_id", sessionID).WithError(err).Warn("Consul session renewal stopped")
+		}
+	}()
+	c.sessionID = sessionID
+	c.renewDoneCh = doneCh
+	return sessionID, nil
+}
+
+// invalidateSession drops the cached session and stops renewing it, so the
+// next TryLock/IsLeader call creates a fresh one instead of repeatedly
+// acquiring against a session consul has already expired.
+func (c *ConsulCoordinator) invalidateSession() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.renewDoneCh != nil {
+		close(c.renewDoneCh)
+		c.renewDoneCh = nil
+	}
+	c.sessionID = ""
+}
+
+func (c *ConsulCoordinator) TryLock(ctx context.Context, key string) (func(), bool, error) {
+	if ctx.Err() != nil {
+		return nil, false, ctx.Err()
+	}
+	sessionID, err := c.session()
+	if err != nil {
+		return nil, false, err
+	}
+	pair := &consulapi.KVPair{Key: "sploit/locks/" + key, Session: sessionID}
+	acquired, _, err := c.client.KV().Acquire(pair, nil)
+	if err != nil {
+		c.invalidateSession()
+		return nil, false, fmt.Errorf("acquiring consul lock %q: %w", key, err)
+	}
+	if !acquired {
+		return nil, false, nil
+	}
+	unlock := func() {
+		c.client.KV().Release(pair, nil)
+	}
+	return unlock, true, nil
+}
+
+func (c *ConsulCoordinator) IsLeader(ctx context.Context) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	sessionID, err := c.session()
+	if err != nil {
+		return false, err
+	}
+	pair := &consulapi.KVPair{Key: "sploit/leader", Session: sessionID}
+	acquired, _, err := c.client.KV().Acquire(pair, nil)
+	if err != nil {
+		c.invalidateSession()
+		return false, fmt.Errorf("acquiring consul leader key: %w", err)
+	}
+	return acquired, nil
+}
+
+// etcdConfig configures the etcd Coordinator backend.
+type etcdConfig struct {
+	Endpoints []string `yaml:"endpoints"`
+	LeaseTTL  string   `yaml:"lease_ttl"`
+}
+
+// EtcdCoordinator coordinates over an etcd cluster using the
+// clientv3/concurrency package's session-scoped mutexes and elections.
+type EtcdCoordinator struct {
+	client   *clientv3.Client
+	leaseTTL int
+
+	mu      sync.Mutex
+	session *concurrency.Session
+}
+
+func NewEtcdCoordinator(cfg etcdConfig) (*EtcdCoordinator, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("building etcd client: %w", err)
+	}
+	ttl := 30
+	if cfg.LeaseTTL != "" {
+		if parsed, err := strconv.Atoi(cfg.LeaseTTL); err == nil {
+			ttl = parsed
+		}
+	}
+	return &EtcdCoordinator{client: client, leaseTTL: ttl}, nil
+}
+
+// etcdSession lazily creates the etcd lease-backed session locks and
+// elections are scoped to, recreating it if the lease has expired.
+func (e *EtcdCoordinator) etcdSession() (*concurrency.Session, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.session != nil {
+		select {
+		case <-e.session.Done():
+			e.session = nil
+		default:
+			return e.session, nil
+		}
+	}
+	session, err := concurrency.NewSession(e.client, concurrency.WithTTL(e.leaseTTL))
+	if err != nil {
+		return nil, fmt.Errorf("creating etcd session: %w", err)
+	}
+	e.session = session
+	return session, nil
+}
+
+func (e *EtcdCoordinator) TryLock(ctx context.Context, key string) (func(), bool, error) {
+	session, err := e.etcdSession()
+	if err != nil {
+		return nil, false, err
+	}
+	mutex := concurrency.NewMutex(session, "/sploit/locks/"+key)
+	if err := mutex.TryLock(ctx); err != nil {
+		if err == concurrency.ErrLocked {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("acquiring etcd lock %q: %w", key, err)
+	}
+	unlock := func() {
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		mutex.Unlock(unlockCtx)
+	}
+	return unlock, true, nil
+}
+
+func (e *EtcdCoordinator) IsLeader(ctx context.Context) (bool, error) {
+	session, err := e.etcdSession()
+	if err != nil {
+		return false, err
+	}
+	election := concurrency.NewElection(session, "/sploit/leader")
+	campaignCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := election.Campaign(campaignCtx, "sploit"); err != nil {
+		if err == context.DeadlineExceeded {
+			return false, nil
+		}
+		return false, fmt.Errorf("campaigning for etcd leadership: %w", err)
+	}
+	return true, nil
+}
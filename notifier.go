@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventKind identifies the category of a notifier Event.
+type EventKind string
+
+const (
+	EventVulnerability EventKind = "new_vuln"
+	EventNewModules    EventKind = "new_modules"
+	EventError         EventKind = "error"
+	EventStatus        EventKind = "status"
+)
+
+// Event is the payload fanned out to every configured Notifier.
+type Event struct {
+	Kind       EventKind
+	Subject    string
+	Body       string
+	Host       string
+	Module     string
+	References []string
+}
+
+// Notifier is implemented by every notification backend sploit.yml can
+// configure under `notifiers:`.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// notifierConfig is one entry of the `notifiers:` list in sploit.yml; only
+// the block matching Type is read.
+type notifierConfig struct {
+	Type    string        `yaml:"type"`
+	SMTP    smtpConfig    `yaml:"smtp"`
+	Webhook webhookConfig `yaml:"webhook"`
+	Slack   slackConfig   `yaml:"slack"`
+}
+
+// buildNotifiers constructs the configured Notifier backends. An entry
+// with an unrecognized type is logged and skipped rather than failing
+// startup.
+func (daemon *Daemon) buildNotifiers() []Notifier {
+	notifiers := []Notifier{}
+	for _, cfg := range daemon.cfg.Notifiers {
+		switch strings.ToLower(cfg.Type) {
+		case "smtp":
+			notifiers = append(notifiers, &SMTPNotifier{cfg: cfg.SMTP})
+		case "webhook":
+			notifiers = append(notifiers, NewWebhookNotifier(cfg.Webhook))
+		case "slack":
+			notifiers = append(notifiers, NewSlackNotifier(cfg.Slack))
+		default:
+			daemon.Logger.WithField("type", cfg.Type).Warn("Unrecognized notifier type in sploit.yml, skipping")
+		}
+	}
+	return notifiers
+}
+
+// notifyAll fans an event out to every configured notifier; a failure in
+// one backend is logged but does not prevent the others from firing.
+func (daemon *Daemon) notifyAll(ctx context.Context, event Event) {
+	for _, notifier := range daemon.notifiers {
+		if err := notifier.Notify(ctx, event); err != nil {
+			daemon.Logger.WithFields(logrus.Fields{"notifier": fmt.Sprintf("%T", notifier), "kind": event.Kind}).WithError(err).Error("Notifier failed to send event")
+		}
+	}
+}
+
+// SMTPNotifier sends events as plain-text email, the original notification
+// mechanism.
+type SMTPNotifier struct {
+	cfg smtpConfig
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, event Event) error {
+	const dateLayout = "Mon, 2 Jan 2006 15:04:05 -0700"
+	body := "From: " + n.cfg.From + "\r\nTo: " + n.cfg.To +
+		"\r\nSubject: " + event.Subject + "\r\nDate: " + time.Now().Format(dateLayout) +
+		"\r\n\r\n" + event.Body
+	domain, _, err := net.SplitHostPort(n.cfg.Host)
+	if err != nil {
+		return fmt.Errorf("net.SplitHostPort: %w", err)
+	}
+	auth := smtp.PlainAuth("", n.cfg.User, n.cfg.Pass, domain)
+	return smtp.SendMail(n.cfg.Host, auth, n.cfg.From, strings.Fields(n.cfg.To), []byte(body))
+}
+
+// webhookConfig configures the generic webhook notifier.
+type webhookConfig struct {
+	URL     string `yaml:"url"`
+	Timeout string `yaml:"timeout"`
+}
+
+// WebhookNotifier POSTs events as JSON to a configured URL, retrying a
+// couple of times with backoff on connection errors and 5xx responses.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(cfg webhookConfig) *WebhookNotifier {
+	timeout := 5 * time.Second
+	if cfg.Timeout != "" {
+		if parsed, err := time.ParseDuration(cfg.Timeout); err == nil {
+			timeout = parsed
+		}
+	}
+	return &WebhookNotifier{
+		url:    cfg.URL,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshaling webhook payload: %w", err)
+	}
+	backoff := 250 * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+		req, err := http.NewRequest("POST", n.url, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("building webhook request: %w", err)
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// slackConfig configures the Slack notifier.
+type slackConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// SlackNotifier formats vulnerability events into Slack blocks with the
+// CVE/reference links; other event kinds are sent as a single text block.
+type SlackNotifier struct {
+	cfg    slackConfig
+	client *http.Client
+}
+
+func NewSlackNotifier(cfg slackConfig) *SlackNotifier {
+	return &SlackNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(slackPayload(event))
+	if err != nil {
+		return fmt.Errorf("marshaling slack payload: %w", err)
+	}
+	req, err := http.NewRequest("POST", n.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building slack request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("slack webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func slackPayload(event Event) map[string]interface{} {
+	text := fmt.Sprintf("*%s*\n%s", event.Subject, event.Body)
+	blocks := []map[string]interface{}{
+		{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": text},
+		},
+	}
+	if event.Kind == EventVulnerability && len(event.References) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "context",
+			"elements": []map[string]string{
+				{"type": "mrkdwn", "text": strings.Join(event.References, " | ")},
+			},
+		})
+	}
+	return map[string]interface{}{"blocks": blocks}
+}
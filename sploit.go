@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	auth "github.com/abbot/go-http-auth"
 	_ "github.com/lib/pq"
 	flag "github.com/ogier/pflag"
-	"github.com/op/go-logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 	"github.com/snarlysodboxer/msfapi"
 	"gopkg.in/fsnotify.v1"
 	"gopkg.in/robfig/cron.v2"
@@ -17,7 +20,6 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/smtp"
 	"os"
 	"os/signal"
 	"path"
@@ -38,12 +40,16 @@ type msfConfig struct {
 }
 
 type sploitConfig struct {
-	WatchDir     string
-	ServicesFile string
-	ServeAddress string
-	UpdateSpec   string
-	StatusSpec   string
-	LogFile      string
+	WatchDir        string
+	ServicesFile    string
+	ServeAddress    string
+	UpdateSpec      string
+	StatusSpec      string
+	LogFile         string
+	LogFormat       string
+	LogLevel        string
+	ShutdownTimeout string
+	APIToken        string
 }
 
 type postgresConfig struct {
@@ -63,10 +69,11 @@ type smtpConfig struct {
 }
 
 type config struct {
-	Sploit   sploitConfig
-	MsfRpc   msfConfig
-	Postgres postgresConfig
-	SMTP     smtpConfig
+	Sploit      sploitConfig
+	MsfRpc      msfConfig
+	Postgres    postgresConfig
+	Notifiers   []notifierConfig
+	Coordinator coordinatorConfig
 }
 
 type module struct {
@@ -74,6 +81,25 @@ type module struct {
 	Commands []string
 	CronSpec string
 	Running  bool
+	Timeout  string
+}
+
+// defaultModuleTimeout bounds a single scan (one createConsoleAndRun call
+// against one host/port) when a module doesn't set its own Timeout.
+const defaultModuleTimeout = 10 * time.Minute
+
+// timeout parses module.Timeout, defaulting to defaultModuleTimeout if
+// unset or unparseable.
+func (module *module) timeout(logger *logrus.Logger) time.Duration {
+	if module.Timeout == "" {
+		return defaultModuleTimeout
+	}
+	parsed, err := time.ParseDuration(module.Timeout)
+	if err != nil {
+		logger.WithFields(logrus.Fields{"module": module.Name, "timeout": module.Timeout}).Warn("Unrecognized module timeout in services.yml, defaulting to 10m")
+		return defaultModuleTimeout
+	}
+	return parsed
 }
 
 type host struct {
@@ -90,25 +116,37 @@ type service struct {
 }
 
 type Daemon struct {
-	Hosts         []host
-	Services      *[]service
-	API           *msfapi.API
-	interruptChan chan os.Signal
-	notifierChan  chan bool
-	errorChan     chan string
-	cron          cron.Cron
-	internalCron  cron.Cron
-	updateRunning bool
-	lastUpdate    time.Time
-	waitGroup     sync.WaitGroup
-	cfg           config
-	configFile    *string
-	db            sql.DB
-	knownVulnIDs  []int
-	knownModules  map[string][]string
-	scanCount     int
-	logWriter     *os.File
-	debugMode     *bool
+	Hosts            []host
+	Services         *[]service
+	API              *msfapi.API
+	Logger           *logrus.Logger
+	interruptChan    chan os.Signal
+	notifierChan     chan bool
+	errorChan        chan string
+	cron             cron.Cron
+	internalCron     cron.Cron
+	updateRunning    bool
+	lastUpdate       time.Time
+	waitGroup        sync.WaitGroup
+	cfg              config
+	configFile       *string
+	db               sql.DB
+	knownVulnIDs     []int
+	knownModules     map[string][]string
+	scanCount        int
+	logWriter        *os.File
+	debugMode        *bool
+	ctx              context.Context
+	cancel           context.CancelFunc
+	shutdownTimeout  time.Duration
+	shutdownComplete chan struct{}
+	httpServer       *http.Server
+	consolesMu       sync.Mutex
+	activeConsoles   map[string]bool
+	notifiers        []Notifier
+	scanEntriesMu    sync.RWMutex
+	scanEntries      map[string]cron.EntryID
+	coordinator      Coordinator
 }
 
 type vulnerability struct {
@@ -119,10 +157,13 @@ type vulnerability struct {
 	References string
 }
 
+// SetupLogging builds daemon.Logger, a structured logrus logger, from the
+// "logformat" ("text" or "json") and "loglevel" settings in sploit.yml. --debug
+// always wins over a configured level so operators can still get verbose
+// output without editing sploit.yml.
 func (daemon *Daemon) SetupLogging() {
-	var logFormat = logging.MustStringFormatter(
-		"%{color}%{time:15:04:05.000} %{level:.8s} %{shortfunc} ▶ %{message}%{color:reset}",
-	)
+	daemon.Logger = logrus.New()
+
 	var multiWriter io.Writer
 	if daemon.cfg.Sploit.LogFile != "" {
 		daemon.logWriter = createAndLoadFile(daemon.cfg.Sploit.LogFile)
@@ -130,15 +171,44 @@ func (daemon *Daemon) SetupLogging() {
 	} else {
 		multiWriter = io.MultiWriter(os.Stderr)
 	}
-	logBackend := logging.NewLogBackend(multiWriter, "", 0)
-	logBackendFormatter := logging.NewBackendFormatter(logBackend, logFormat)
-	logBackendLeveled := logging.AddModuleLevel(logBackendFormatter)
-	logging.SetBackend(logBackendLeveled)
+	daemon.Logger.Out = multiWriter
+
+	switch strings.ToLower(daemon.cfg.Sploit.LogFormat) {
+	case "json":
+		daemon.Logger.Formatter = &logrus.JSONFormatter{}
+	default:
+		daemon.Logger.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	}
+
+	level := logrus.InfoLevel
+	if daemon.cfg.Sploit.LogLevel != "" {
+		if parsed, err := logrus.ParseLevel(daemon.cfg.Sploit.LogLevel); err == nil {
+			level = parsed
+		} else {
+			daemon.Logger.WithField("level", daemon.cfg.Sploit.LogLevel).Warn("Unrecognized log level in sploit.yml, defaulting to info")
+		}
+	}
 	if *daemon.debugMode {
-		logBackendLeveled.SetLevel(logging.DEBUG, "sploit")
-	} else {
-		logBackendLeveled.SetLevel(logging.INFO, "sploit")
+		level = logrus.DebugLevel
 	}
+	daemon.Logger.SetLevel(level)
+
+	// keep the package-level logger, used by code that runs before
+	// SetupLogging or has no Daemon at hand, in sync with the configured one
+	log = daemon.Logger
+}
+
+// reportError logs a structured error event with the given fields, tagging
+// it with component=sploit so it can be alerted on without parsing the
+// message body, and forwards the plain-text message on errorChan for the
+// error notifier.
+func (daemon *Daemon) reportError(fields logrus.Fields, message string) {
+	if fields == nil {
+		fields = logrus.Fields{}
+	}
+	fields["component"] = "sploit"
+	daemon.Logger.WithFields(fields).Error(message)
+	daemon.errorChan <- message
 }
 
 func (daemon *Daemon) LoadFlags() {
@@ -160,28 +230,141 @@ func (daemon *Daemon) LoadFlags() {
 	}
 }
 
-// supply a mechanism for stopping
+// supply a mechanism for stopping: on SIGINT/SIGTERM, stop accepting new
+// cron triggers, shut down the webserver so no new on-demand scan can be
+// triggered via the API, cancel daemon.ctx so in-flight scans can abort,
+// wait on daemon.waitGroup up to ShutdownTimeout for them to finish,
+// destroy any consoles still open, then tear down the auth token, DB and
+// log file in that order before signalling shutdownComplete. If the
+// timeout elapses, the still-running modules are logged and the process
+// exits non-zero.
 func (daemon *Daemon) CreateInterruptChannel() {
+	daemon.shutdownTimeout = 30 * time.Second
+	if daemon.cfg.Sploit.ShutdownTimeout != "" {
+		if parsed, err := time.ParseDuration(daemon.cfg.Sploit.ShutdownTimeout); err == nil {
+			daemon.shutdownTimeout = parsed
+		} else {
+			daemon.Logger.WithField("shutdown_timeout", daemon.cfg.Sploit.ShutdownTimeout).Warn("Unrecognized shutdowntimeout in sploit.yml, defaulting to 30s")
+		}
+	}
+
 	daemon.interruptChan = make(chan os.Signal, 1)
 	signal.Notify(daemon.interruptChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for _ = range daemon.interruptChan {
-			log.Info("Closing....")
-			daemon.cron.Stop()                                  // Stop the scheduler (does not stop any jobs already running).
-			err := daemon.API.AuthTokenRemove(daemon.API.Token) // essentially logout
-			if err != nil {
-				message := fmt.Sprintf("Error removing Auth token:\n%v", err)
-				log.Critical(message)
-				daemon.errorChan <- message
+		<-daemon.interruptChan
+		daemon.Logger.Info("Received shutdown signal, closing....")
+
+		// Stop the scheduler (does not stop any jobs already running).
+		daemon.cron.Stop()
+		daemon.internalCron.Stop()
+
+		// Stop the webserver before waiting on daemon.waitGroup: it's what
+		// accepts apiScanRunHandler's on-demand scan requests, and each of
+		// those calls daemon.waitGroup.Add(1). Shutting it down first closes
+		// that door, so no new Add can race the Wait below.
+		if daemon.httpServer != nil {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := daemon.httpServer.Shutdown(shutdownCtx); err != nil {
+				daemon.Logger.WithError(err).Error("Error shutting down webserver")
 			}
-			log.Debug("Removed auth token %v", daemon.API.Token)
-			defer daemon.db.Close()
-			defer daemon.logWriter.Close()
-			daemon.waitGroup.Done()
+			shutdownCancel()
 		}
+
+		daemon.cancel()
+
+		waitDone := make(chan struct{})
+		go func() {
+			daemon.waitGroup.Wait()
+			close(waitDone)
+		}()
+
+		timedOut := false
+		select {
+		case <-waitDone:
+			daemon.Logger.Info("All in-flight scans finished")
+		case <-time.After(daemon.shutdownTimeout):
+			timedOut = true
+			daemon.Logger.WithField("running_modules", daemon.runningModuleNames()).Warn("Shutdown timeout elapsed with modules still running")
+		}
+
+		daemon.destroyOutstandingConsoles()
+
+		if err := daemon.API.AuthTokenRemove(daemon.API.Token); err != nil { // essentially logout
+			daemon.Logger.WithError(err).Error("Error removing auth token")
+		} else {
+			daemon.Logger.WithField("token", daemon.API.Token).Debug("Removed auth token")
+		}
+
+		if err := daemon.db.Close(); err != nil {
+			daemon.Logger.WithError(err).Error("Error closing database connection")
+		}
+
+		if daemon.logWriter != nil {
+			if err := daemon.logWriter.Close(); err != nil {
+				daemon.Logger.WithError(err).Error("Error closing log file")
+			}
+		}
+
+		if timedOut {
+			os.Exit(1)
+		}
+		close(daemon.shutdownComplete)
 	}()
 }
 
+// runningModuleNames returns the names of modules still marked Running,
+// for logging when shutdown times out.
+func (daemon *Daemon) runningModuleNames() []string {
+	running := []string{}
+	if daemon.Services == nil {
+		return running
+	}
+	for _, daemonService := range *daemon.Services {
+		for _, module := range daemonService.Modules {
+			if module.Running {
+				running = append(running, module.Name)
+			}
+		}
+	}
+	return running
+}
+
+// trackConsole records a console as open so it can be force-destroyed if
+// shutdown times out while it's still in use.
+func (daemon *Daemon) trackConsole(id string) {
+	daemon.consolesMu.Lock()
+	defer daemon.consolesMu.Unlock()
+	if daemon.activeConsoles == nil {
+		daemon.activeConsoles = map[string]bool{}
+	}
+	daemon.activeConsoles[id] = true
+}
+
+// untrackConsole removes a console once it's been cleanly destroyed.
+func (daemon *Daemon) untrackConsole(id string) {
+	daemon.consolesMu.Lock()
+	defer daemon.consolesMu.Unlock()
+	delete(daemon.activeConsoles, id)
+}
+
+// destroyOutstandingConsoles force-destroys any consoles still open after
+// the shutdown wait, e.g. ones stuck in a wedged module.
+func (daemon *Daemon) destroyOutstandingConsoles() {
+	daemon.consolesMu.Lock()
+	ids := make([]string, 0, len(daemon.activeConsoles))
+	for id := range daemon.activeConsoles {
+		ids = append(ids, id)
+	}
+	daemon.consolesMu.Unlock()
+	for _, id := range ids {
+		daemon.Logger.WithField("console_id", id).Warn("Destroying outstanding console during shutdown")
+		if err := daemon.API.ConsoleDestroy(id); err != nil {
+			daemon.Logger.WithField("console_id", id).WithError(err).Error("Error destroying outstanding console")
+		}
+		daemon.untrackConsole(id)
+	}
+}
+
 // read sploit.yml and set settings
 func (daemon *Daemon) LoadSploitYaml() {
 	contents, err := ioutil.ReadFile(*daemon.configFile)
@@ -201,31 +384,25 @@ func (daemon *Daemon) LoadSploitYaml() {
 func (daemon *Daemon) LoadServicesYaml() {
 	contents, err := ioutil.ReadFile(daemon.cfg.Sploit.ServicesFile)
 	if err != nil {
-		message := fmt.Sprintf("Error loading services.yml YAML file:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "LoadServicesYaml"}, fmt.Sprintf("error loading services.yml YAML file: %v", err))
 		return
 	}
 	services := []service{}
 	err = yaml.Unmarshal([]byte(contents), &services)
 	if err != nil {
-		message := fmt.Sprintf("Error unmarshaling YAML file:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "LoadServicesYaml"}, fmt.Sprintf("error unmarshaling YAML file: %v", err))
 		return
 	}
 	daemon.Services = &services // overwrite old
-	log.Info("Successfully loaded services.yml file")
-	log.Debug("Services/Modules config is: %v", services)
+	daemon.Logger.Info("Successfully loaded services.yml file")
+	daemon.Logger.WithField("services", services).Debug("Services/Modules config loaded")
 }
 
 // read host.yml files from host.d into daemon.Hosts
 func (daemon *Daemon) LoadHostYamls() {
 	files, err := ioutil.ReadDir(daemon.cfg.Sploit.WatchDir)
 	if err != nil {
-		message := fmt.Sprintf("Error reading host.d directory:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "LoadHostYamls"}, fmt.Sprintf("error reading host.d directory: %v", err))
 		return
 	}
 	hosts := []host{}
@@ -235,17 +412,13 @@ func (daemon *Daemon) LoadHostYamls() {
 			if regex.MatchString(file.Name()) {
 				contents, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", daemon.cfg.Sploit.WatchDir, file.Name()))
 				if err != nil {
-					message := fmt.Sprintf("Error loading YAML file:\n%v", err)
-					log.Critical(message)
-					daemon.errorChan <- message
+					daemon.reportError(logrus.Fields{"operation": "LoadHostYamls"}, fmt.Sprintf("error loading YAML file: %v", err))
 					return
 				}
 				host := host{}
 				err = yaml.Unmarshal([]byte(contents), &host)
 				if err != nil {
-					message := fmt.Sprintf("Error unmarshaling YAML file:\n%v", err)
-					log.Critical(message)
-					daemon.errorChan <- message
+					daemon.reportError(logrus.Fields{"operation": "LoadHostYamls"}, fmt.Sprintf("error unmarshaling YAML file: %v", err))
 					return
 				}
 				hosts = append(hosts, host)
@@ -253,8 +426,8 @@ func (daemon *Daemon) LoadHostYamls() {
 		}
 	}
 	daemon.Hosts = hosts // overwrite old
-	log.Info("Successfully loaded hosts yaml files")
-	log.Debug("Host configs are: %v", hosts)
+	daemon.Logger.Info("Successfully loaded hosts yaml files")
+	daemon.Logger.WithField("hosts", hosts).Debug("Host configs loaded")
 }
 
 func (daemon *Daemon) SetupAPIToken() {
@@ -266,41 +439,40 @@ func (daemon *Daemon) SetupAPIToken() {
 		conn, err := net.Listen("tcp", address)
 		if err == nil {
 			conn.Close()
-			log.Debug(fmt.Sprintf("Waiting 3 seconds for msfrpcd to take port %s", daemon.cfg.MsfRpc.Port))
+			daemon.Logger.WithField("port", daemon.cfg.MsfRpc.Port).Debug("Waiting 3 seconds for msfrpcd to take port")
 			time.Sleep(3 * time.Second)
 		} else {
-			log.Debug(fmt.Sprintf("%s", err))
-			log.Debug(fmt.Sprintf("msfrpcd has taken port %s, continuing", daemon.cfg.MsfRpc.Port))
+			daemon.Logger.WithField("port", daemon.cfg.MsfRpc.Port).WithError(err).Debug("msfrpcd has taken port, continuing")
 			open = true
 		}
 	}
 
 	tempToken, err := daemon.API.AuthLogin(daemon.cfg.MsfRpc.User, daemon.cfg.MsfRpc.Pass)
 	if err != nil {
-		log.Fatalf("Error with AuthLogin: %s", err)
+		log.WithError(err).Fatal("Error with AuthLogin")
 	}
-	log.Debug("Logged into the MSF API, got temporary auth token: %s", tempToken)
+	daemon.Logger.WithField("token", tempToken).Debug("Logged into the MSF API, got temporary auth token")
 	daemon.API.Token = tempToken
 
 	permToken := strings.Replace(tempToken, "TEMP", "PERM", -1)
 	err = daemon.API.AuthTokenAdd(permToken)
 	if err != nil {
-		log.Fatalf("Error with AuthTokenAdd: %s", err)
+		log.WithError(err).Fatal("Error with AuthTokenAdd")
 	}
-	log.Debug("Added permanent auth token: %s", permToken)
+	daemon.Logger.WithField("token", permToken).Debug("Added permanent auth token")
 	daemon.API.Token = permToken
 
 	err = daemon.API.AuthTokenRemove(tempToken)
 	if err != nil {
-		log.Fatalf("Error with AuthTokenRemove: %s", err)
+		log.WithError(err).Fatal("Error with AuthTokenRemove")
 	}
-	log.Debug("Removed temporary auth token: %v", tempToken)
+	daemon.Logger.WithField("token", tempToken).Debug("Removed temporary auth token")
 
 	tokens, err := daemon.API.AuthTokenList()
 	if err != nil {
-		log.Fatalf("Error with AuthTokenList: %s", err)
+		log.WithError(err).Fatal("Error with AuthTokenList")
 	}
-	log.Debug("Current Token list: %v", tokens)
+	daemon.Logger.WithField("tokens", tokens).Debug("Current token list")
 }
 
 func (daemon *Daemon) OpenDBConnection() {
@@ -309,7 +481,7 @@ func (daemon *Daemon) OpenDBConnection() {
 		"user=%s password=%s host=%s port=%s dbname=%s sslmode=disable",
 		cfg.User, cfg.Pass, cfg.Host, cfg.Port, cfg.DB,
 	)
-	log.Debug(fmt.Sprintf("Using PostgreSQL login credentials \"%s\"", credentials))
+	daemon.Logger.WithFields(logrus.Fields{"host": cfg.Host, "port": cfg.Port, "db": cfg.DB}).Debug("Using PostgreSQL login credentials")
 	db, err := sql.Open("postgres", credentials)
 	if err != nil {
 		log.Fatal(err)
@@ -319,42 +491,57 @@ func (daemon *Daemon) OpenDBConnection() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Info("Successfully opened a database connection")
+	daemon.Logger.Info("Successfully opened a database connection")
 }
 
 // setup cron entries which send api calls to msfrpcd
 func (daemon *Daemon) CreateCronEntries() {
-	for _, daemonService := range *daemon.Services {
-		for _, module := range daemonService.Modules {
-			log.Info("Creating a cron entry for: %v", module.Name)
+	for i := range *daemon.Services {
+		daemonService := &(*daemon.Services)[i]
+		for j := range daemonService.Modules {
+			module := &daemonService.Modules[j]
+			daemon.Logger.WithFields(logrus.Fields{"module": module.Name, "service": daemonService.Name}).Info("Creating a cron entry")
 			module.Running = false
-			_, err := daemon.cron.AddFunc(module.CronSpec, func() {
-				daemon.runModuleAgainstEachHostPort(daemonService.Name, &module)
+			serviceName := daemonService.Name
+			entryID, err := daemon.cron.AddFunc(module.CronSpec, func() {
+				daemon.waitGroup.Add(1)
+				defer daemon.waitGroup.Done()
+				daemon.runModuleAgainstEachHostPort(daemon.ctx, serviceName, module)
 			})
 			if err != nil {
-				message := fmt.Sprintf("Error adding cron entry:\n%v", err)
-				log.Critical(message)
-				daemon.errorChan <- message
+				daemon.reportError(logrus.Fields{"module": module.Name, "service": daemonService.Name}, fmt.Sprintf("error adding cron entry: %v", err))
 				return
 			}
-			daemon.waitGroup.Add(1)
+			daemon.scanEntriesMu.Lock()
+			daemon.scanEntries[module.Name] = entryID
+			daemon.scanEntriesMu.Unlock()
 		}
 	}
 }
 
 // to be run by cron
-func (daemon *Daemon) runModuleAgainstEachHostPort(serviceName string, module *module) {
-	log.Info("Triggered cron entry for module %s", module.Name)
+func (daemon *Daemon) runModuleAgainstEachHostPort(ctx context.Context, serviceName string, module *module) {
+	logFields := logrus.Fields{"module": module.Name, "service": serviceName}
+	daemon.Logger.WithFields(logFields).Info("Triggered cron entry for module")
 	if module.Running {
-		log.Warning("Module %s is already running, not running again.", module.Name)
+		daemon.Logger.WithFields(logFields).Warn("Module is already running, not running again")
 		return
 	}
 	startTime := time.Now()
 	module.Running = true
+	scansTotal.WithLabelValues(module.Name, serviceName).Inc()
+	defer func() {
+		module.Running = false
+		scanDuration.WithLabelValues(module.Name, serviceName).Observe(time.Since(startTime).Seconds())
+	}()
 	for _, host := range daemon.Hosts {
 		for _, hostService := range host.Services {
 			if hostService.Name == serviceName {
 				for _, port := range hostService.Ports {
+					if ctx.Err() != nil {
+						daemon.Logger.WithFields(logFields).Warn("Shutting down, aborting remaining hosts/ports for this module run")
+						return
+					}
 					var commands []string
 					for _, command := range module.Commands {
 						cmd := strings.Replace(command, "SPLOITHOSTNAME", host.Name, -1)
@@ -363,23 +550,40 @@ func (daemon *Daemon) runModuleAgainstEachHostPort(serviceName string, module *m
 						commands = append(commands, cmd)
 					}
 
-					log.Info("Initiating '%s' to run against port '%d' on '%v'.",
-						module.Name, port, host.Name)
-					log.Debug("Module details: %v", *module)
-					log.Debug("Commands that will be run: %v", commands)
-					_, err := daemon.createConsoleAndRun(commands)
+					hostPortFields := logrus.Fields{"module": module.Name, "service": serviceName, "host": host.Name, "port": port}
+
+					lockKey := fmt.Sprintf("%s/%s/%d", module.Name, host.Name, port)
+					unlock, acquired, err := daemon.coordinator.TryLock(ctx, lockKey)
+					if err != nil {
+						daemon.Logger.WithFields(hostPortFields).WithError(err).Warn("Coordinator unavailable, skipping scan for this host/port")
+						continue
+					}
+					if !acquired {
+						daemon.Logger.WithFields(hostPortFields).Debug("Another node holds the lock for this host/port, skipping")
+						continue
+					}
+
+					daemon.Logger.WithFields(hostPortFields).Info("Initiating module run")
+					daemon.Logger.WithFields(hostPortFields).WithField("commands", module.Commands).Debug("Module details")
+					daemon.Logger.WithFields(hostPortFields).WithField("commands", commands).Debug("Commands that will be run")
+					scanCtx, scanCancel := context.WithTimeout(ctx, module.timeout(daemon.Logger))
+					_, err = daemon.createConsoleAndRun(scanCtx, commands)
+					scanCancel()
+					unlock()
 					if err != nil {
-						message := fmt.Sprintf("Error running commands in console:\n%v", err)
-						log.Critical(message)
-						daemon.errorChan <- message
+						var timeoutErr *ConsoleTimeoutError
+						if errors.As(err, &timeoutErr) {
+							scanTimeoutsTotal.WithLabelValues(module.Name, serviceName).Inc()
+						}
+						daemon.reportError(hostPortFields, fmt.Sprintf("error running commands in console: %v", err))
 						return
 					}
 				}
 			}
 		}
 	}
-	log.Info("%v took %v to run", module.Name, time.Since(startTime))
-	module.Running = false
+	duration := time.Since(startTime)
+	daemon.Logger.WithFields(logFields).WithField("duration_ms", duration.Milliseconds()).Info("Module run finished")
 	daemon.scanCount = daemon.scanCount + 1
 	daemon.notifierChan <- true
 }
@@ -415,10 +619,10 @@ func (daemon *Daemon) selectVulns() (*[]vulnerability, error) {
 		}
 		vuln.References = strings.Replace(vuln.References, ",", " ", -1)
 		vuln.References = strings.Replace(vuln.References, "-http", " http", 1)
-		log.Debug("Vulnerability found:\n%v %v %v %v",
-			vuln.CreatedAt, vuln.Address, vuln.Name, vuln.References)
+		daemon.Logger.WithFields(logrus.Fields{"vuln_id": vuln.id, "host": vuln.Address}).Debug("Vulnerability found")
 		vulnerabilities = append(vulnerabilities, vuln)
 	}
+	vulnsKnown.Set(float64(len(vulnerabilities)))
 	return &vulnerabilities, nil
 }
 
@@ -426,9 +630,7 @@ func (daemon *Daemon) selectVulns() (*[]vulnerability, error) {
 func (daemon *Daemon) recordAndNotify() {
 	vulns, err := daemon.selectVulns()
 	if err != nil {
-		message := fmt.Sprintf("Error selecting vulns from the database:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "recordAndNotify"}, fmt.Sprintf("error selecting vulns from the database: %v", err))
 		return
 	}
 	for _, vuln := range *vulns {
@@ -439,43 +641,34 @@ func (daemon *Daemon) recordAndNotify() {
 			}
 		}
 		if known {
-			log.Debug("Vulnerabilty %v is already known, not notifying", vuln.id)
+			daemon.Logger.WithField("vuln_id", vuln.id).Debug("Vulnerability is already known, not notifying")
 		} else {
 			var subject = fmt.Sprintf("New Vulnerability found on %s", vuln.Address)
 			var message = fmt.Sprintf("Found the folowing Vulnerability on %s\n\n%s %s %s %s",
 				vuln.Address, vuln.CreatedAt, vuln.Address, vuln.Name, vuln.References,
 			)
-			daemon.sendEmail(&subject, &message)
+			daemon.notifyAll(daemon.ctx, Event{
+				Kind:       EventVulnerability,
+				Subject:    subject,
+				Body:       message,
+				Host:       vuln.Address,
+				References: strings.Fields(vuln.References),
+			})
 			daemon.knownVulnIDs = append(daemon.knownVulnIDs, vuln.id)
 		}
 	}
 }
 
-func (daemon *Daemon) sendEmail(subject, message *string) {
-	const dateLayout = "Mon, 2 Jan 2006 15:04:05 -0700"
-	body := "From: " + daemon.cfg.SMTP.From + "\r\nTo: " + daemon.cfg.SMTP.To +
-		"\r\nSubject: " + *subject + "\r\nDate: " + time.Now().Format(dateLayout) +
-		"\r\n\r\n" + *message
-	domain, _, err := net.SplitHostPort(daemon.cfg.SMTP.Host)
-	if err != nil {
-		log.Critical("Error with net.SplitHostPort: %v", err)
-	}
-	auth := smtp.PlainAuth("", daemon.cfg.SMTP.User, daemon.cfg.SMTP.Pass, domain)
-	err = smtp.SendMail(daemon.cfg.SMTP.Host, auth, daemon.cfg.SMTP.From,
-		strings.Fields(daemon.cfg.SMTP.To), []byte(body))
-	if err != nil {
-		log.Critical("Error with smtp.SendMail: %v\n\n", err)
-		log.Critical("Body: %v\n\n", body)
-	}
-}
-
 // remove all cron daemon entries
 func (daemon *Daemon) RemoveCronEntries() {
 	entries := daemon.cron.Entries()
 	for _, entry := range entries {
-		log.Debug("Removing cron entry id %v", entry.ID)
+		daemon.Logger.WithField("cron_entry_id", entry.ID).Debug("Removing cron entry")
 		daemon.cron.Remove(entry.ID)
 	}
+	daemon.scanEntriesMu.Lock()
+	daemon.scanEntries = map[string]cron.EntryID{}
+	daemon.scanEntriesMu.Unlock()
 }
 
 // watch modules.yml file and host.d dir and recreate all cron entries upon changes
@@ -500,15 +693,13 @@ func (daemon *Daemon) CreateWatchers() {
 					regex := regexp.MustCompilePOSIX(".*.yml$")
 					if regex.MatchString(evnt.Name) {
 						timer.Reset(3 * time.Second)
-						log.Debug("Reset timer for event: %v", evnt)
+						daemon.Logger.WithField("event", evnt).Debug("Reset timer for config file event")
 						event = evnt.Name
 					} else {
-						log.Debug(fmt.Sprintf("Skipping file event: %s", evnt.Name))
+						daemon.Logger.WithField("event", evnt.Name).Debug("Skipping file event")
 					}
 				case err := <-watcher.Errors:
-					message := fmt.Sprintf("Error with file watcher:\n%v", err)
-					log.Critical(message)
-					daemon.errorChan <- message
+					daemon.reportError(logrus.Fields{"operation": "CreateWatchers"}, fmt.Sprintf("error with file watcher: %v", err))
 				}
 			}
 		}()
@@ -517,7 +708,7 @@ func (daemon *Daemon) CreateWatchers() {
 			for {
 				select {
 				case <-timer.C:
-					log.Info("Reloading configuration after %v write", event)
+					daemon.Logger.WithField("event", event).Info("Reloading configuration after write")
 					daemon.RemoveCronEntries()
 					daemon.LoadServicesYaml()
 					daemon.LoadHostYamls()
@@ -541,11 +732,24 @@ func (daemon *Daemon) CreateWatchers() {
 // serve html
 func (daemon *Daemon) CreateWebserver() {
 	authenticator := daemon.loadDigestAuth("Sploit")
-	http.HandleFunc("/", auth.JustCheck(&authenticator, daemon.rootHandler))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", auth.JustCheck(&authenticator, daemon.rootHandler))
+	mux.HandleFunc("/api/v1/hosts", daemon.requireAuth(&authenticator, daemon.apiHostsHandler))
+	mux.HandleFunc("/api/v1/services", daemon.requireAuth(&authenticator, daemon.apiServicesHandler))
+	mux.HandleFunc("/api/v1/vulns", daemon.requireAuth(&authenticator, daemon.apiVulnsHandler))
+	mux.HandleFunc("/api/v1/scans", daemon.requireAuth(&authenticator, daemon.apiScansHandler))
+	mux.HandleFunc("/api/v1/scans/", daemon.requireAuth(&authenticator, daemon.apiScanRunHandler))
+	mux.HandleFunc("/metrics", daemon.requireAuth(&authenticator, promhttp.Handler().ServeHTTP))
+	daemon.httpServer = &http.Server{
+		Addr:    daemon.cfg.Sploit.ServeAddress,
+		Handler: mux,
+	}
 	go func() {
-		http.ListenAndServe(daemon.cfg.Sploit.ServeAddress, nil)
+		if err := daemon.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			daemon.reportError(logrus.Fields{"operation": "CreateWebserver"}, fmt.Sprintf("error running webserver: %v", err))
+		}
 	}()
-	log.Info("Started webserver on %v", daemon.cfg.Sploit.ServeAddress)
+	daemon.Logger.WithField("address", daemon.cfg.Sploit.ServeAddress).Info("Started webserver")
 }
 
 func (daemon *Daemon) rootHandler(writer http.ResponseWriter, request *http.Request) {
@@ -556,9 +760,7 @@ func (daemon *Daemon) rootHandler(writer http.ResponseWriter, request *http.Requ
 	data.CronEntries = daemon.cron.Entries()
 	vulns, err := daemon.selectVulns()
 	if err != nil {
-		message := fmt.Sprintf("Error selecting vulns from the database:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "rootHandler"}, fmt.Sprintf("error selecting vulns from the database: %v", err))
 	}
 	data.Vulns = *vulns
 	tmpl := template.Must(template.ParseFiles("root.html"))
@@ -569,49 +771,63 @@ func (daemon *Daemon) rootHandler(writer http.ResponseWriter, request *http.Requ
 func (daemon *Daemon) CreateUpdaterNotifier() {
 	daemon.internalCron = *cron.New()
 	daemon.updateRunning = false
-	_, err := daemon.internalCron.AddFunc(daemon.cfg.Sploit.UpdateSpec, daemon.updateMsf)
+	_, err := daemon.internalCron.AddFunc(daemon.cfg.Sploit.UpdateSpec, func() {
+		daemon.waitGroup.Add(1)
+		defer daemon.waitGroup.Done()
+		daemon.updateMsf(daemon.ctx)
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Info("Setup to msfupdate on this schedule '%v'", daemon.cfg.Sploit.UpdateSpec)
+	daemon.Logger.WithField("schedule", daemon.cfg.Sploit.UpdateSpec).Info("Setup to msfupdate on this schedule")
 	daemon.internalCron.Start()
 }
 
-func (daemon *Daemon) updateMsf() {
+func (daemon *Daemon) updateMsf(ctx context.Context) {
 	if daemon.updateRunning {
-		log.Warning("An update of MSF is already running, not running again.")
+		daemon.Logger.Warn("An update of MSF is already running, not running again")
 		return
 	}
 	daemon.updateRunning = true
-	log.Info("Beginning an update of MSF via Git.")
+	defer func() { daemon.updateRunning = false }()
+
+	unlock, acquired, err := daemon.coordinator.TryLock(ctx, "msfupdate")
+	if err != nil {
+		daemon.Logger.WithError(err).Warn("Coordinator unavailable, skipping msfupdate on this node")
+		return
+	}
+	if !acquired {
+		daemon.Logger.Debug("Another node is already running msfupdate, skipping")
+		return
+	}
+	defer unlock()
+
+	daemon.Logger.Info("Beginning an update of MSF via Git")
 
 	commands := []string{
 		"msfupdate",
 	}
-	responseData, err := daemon.createConsoleAndRun(commands)
+	responseData, err := daemon.createConsoleAndRun(ctx, commands)
 	if err != nil {
-		message := fmt.Sprintf("Error running commands in console:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "updateMsf"}, fmt.Sprintf("error running commands in console: %v", err))
 		return
 	}
 	regex := regexp.MustCompilePOSIX("No updates available")
 	// skip the rest if msfupdate results in "No updates available"
 	if regex.MatchString(responseData) {
-		log.Info("MSF is already up to date")
-		daemon.updateRunning = false
-		daemon.lastUpdate = time.Now()
+		daemon.Logger.Info("MSF is already up to date")
+		now := time.Now()
+		daemon.lastUpdate = now
+		msfUpdateLastSuccess.Set(float64(now.Unix()))
 		return
 	}
 
 	commands = []string{
 		"reload_all",
 	}
-	_, err = daemon.createConsoleAndRun(commands)
+	_, err = daemon.createConsoleAndRun(ctx, commands)
 	if err != nil {
-		message := fmt.Sprintf("Error running commands in console:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "updateMsf"}, fmt.Sprintf("error running commands in console: %v", err))
 		return
 	}
 
@@ -624,11 +840,9 @@ func (daemon *Daemon) updateMsf() {
 	commands = []string{
 		buffer.String(),
 	}
-	responseData, err = daemon.createConsoleAndRun(commands)
+	responseData, err = daemon.createConsoleAndRun(ctx, commands)
 	if err != nil {
-		message := fmt.Sprintf("Error running commands in console:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "updateMsf"}, fmt.Sprintf("error running commands in console: %v", err))
 		return
 	}
 	for _, service := range *daemon.Services {
@@ -652,80 +866,75 @@ func (daemon *Daemon) updateMsf() {
 			var message = fmt.Sprintf("After updating MSF, found the following new modules with '%s' in the name/description:\t\n%s",
 				service.Name, strings.Join(newModules, "\n"),
 			)
-			daemon.sendEmail(&subject, &message)
+			daemon.notifyAll(ctx, Event{
+				Kind:    EventNewModules,
+				Subject: subject,
+				Body:    message,
+				Module:  service.Name,
+			})
 		} else {
-			log.Info("No new modules matching '%s' were found.", service.Name)
+			daemon.Logger.WithField("service", service.Name).Info("No new modules matching were found")
 		}
 	}
 
-	daemon.updateRunning = false
-	daemon.lastUpdate = time.Now()
+	now := time.Now()
+	daemon.lastUpdate = now
+	msfUpdateLastSuccess.Set(float64(now.Unix()))
 }
 
-// create console and run commands
-func (daemon *Daemon) createConsoleAndRun(commands []string) (string, error) {
-	var buffer bytes.Buffer
+// create console and run commands. See console.go for the bounded,
+// backoff-driven read loop this uses to wait out `Busy` responses.
+func (daemon *Daemon) createConsoleAndRun(ctx context.Context, commands []string) (string, error) {
 	console, err := daemon.API.ConsoleCreate()
 	if err != nil {
-		message := fmt.Sprintf("Error with ConsoleCreate:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		consoleErrorsTotal.Inc()
+		daemon.reportError(logrus.Fields{"operation": "createConsoleAndRun"}, fmt.Sprintf("error with ConsoleCreate: %v", err))
 		return "", err
 	}
-	log.Debug("New console allocated: %v", console)
+	daemon.trackConsole(console.ID)
+	daemon.Logger.WithField("console_id", console.ID).Debug("New console allocated")
 
 	_, err = daemon.API.ConsoleRead(console.ID)
 	if err != nil {
-		message := fmt.Sprintf("Error with ConsoleRead:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		consoleErrorsTotal.Inc()
+		daemon.reportError(logrus.Fields{"operation": "createConsoleAndRun", "console_id": console.ID}, fmt.Sprintf("error with ConsoleRead: %v", err))
+		daemon.destroyConsole(console.ID)
 		return "", err
 	}
-	log.Debug("Discarded console banner.")
+	daemon.Logger.WithField("console_id", console.ID).Debug("Discarded console banner")
 
+	output := newRingBuffer(consoleOutputBufferSize)
 	for _, command := range commands {
 		command = fmt.Sprintf("%s\n", command)
 		err = daemon.API.ConsoleWrite(console.ID, command)
 		if err != nil {
-			message := fmt.Sprintf("Error with ConsoleWrite:\n%v", err)
-			log.Critical(message)
-			daemon.errorChan <- message
+			consoleErrorsTotal.Inc()
+			daemon.reportError(logrus.Fields{"operation": "createConsoleAndRun", "console_id": console.ID}, fmt.Sprintf("error with ConsoleWrite: %v", err))
+			daemon.destroyConsole(console.ID)
 			return "", err
 		}
-		log.Debug("Wrote '%#v' to console %v", command, console.ID)
-		// don't read too soon or you get blank response
-		time.Sleep(750 * time.Millisecond)
-		busy := true
-		for busy {
-			response, err := daemon.API.ConsoleRead(console.ID)
-			if err != nil {
-				message := fmt.Sprintf("Error with ConsoleRead:\n%v", err)
-				log.Critical(message)
-				daemon.errorChan <- message
-				return "", err
-			}
-			if response.Data != "" {
-				log.Debug("Read console %v output:\n%v", console.ID, response.Data)
-			}
-			buffer.WriteString(response.Data)
-			if response.Busy {
-				log.Debug("Console %v is still busy, sleeping 3 seconds..", console.ID)
-				time.Sleep(3 * time.Second)
-			} else {
-				busy = false
-			}
+		daemon.Logger.WithFields(logrus.Fields{"console_id": console.ID, "command": command}).Debug("Wrote command to console")
+
+		if err := daemon.readUntilIdle(ctx, console.ID, output); err != nil {
+			daemon.destroyConsole(console.ID)
+			return "", err
 		}
 	}
 
-	err = daemon.API.ConsoleDestroy(console.ID)
-	if err != nil {
-		message := fmt.Sprintf("Error with ConsoleDestroy:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
-		return "", err
+	daemon.destroyConsole(console.ID)
+	return output.String(), nil
+}
+
+// destroyConsole destroys a console and stops tracking it for forced
+// shutdown cleanup, logging but not failing the caller if destruction
+// itself errors (the caller has already gotten its result or error).
+func (daemon *Daemon) destroyConsole(id string) {
+	defer daemon.untrackConsole(id)
+	if err := daemon.API.ConsoleDestroy(id); err != nil {
+		daemon.Logger.WithField("console_id", id).WithError(err).Error("Error with ConsoleDestroy")
+		return
 	}
-	log.Debug("Successfully removed console %v", console)
-	return buffer.String(), nil
+	daemon.Logger.WithField("console_id", id).Debug("Successfully removed console")
 }
 
 // regular status/update email
@@ -737,6 +946,16 @@ func (daemon *Daemon) CreateStatusNotifier() {
 }
 
 func (daemon *Daemon) sendStatusEmail() {
+	isLeader, err := daemon.coordinator.IsLeader(daemon.ctx)
+	if err != nil {
+		daemon.Logger.WithError(err).Warn("Coordinator unavailable, skipping status email on this node")
+		return
+	}
+	if !isLeader {
+		daemon.Logger.Debug("Not the elected leader, skipping status email")
+		return
+	}
+
 	var subject = "MSF regular Status Update"
 	var message bytes.Buffer
 	// last successful MSF update
@@ -750,9 +969,7 @@ func (daemon *Daemon) sendStatusEmail() {
 	// known vulns
 	vulns, err := daemon.selectVulns()
 	if err != nil {
-		message := fmt.Sprintf("Error selecting vulns from the database:\n%v", err)
-		log.Critical(message)
-		daemon.errorChan <- message
+		daemon.reportError(logrus.Fields{"operation": "sendStatusEmail"}, fmt.Sprintf("error selecting vulns from the database: %v", err))
 		return
 	}
 	if len(*vulns) > 0 {
@@ -765,25 +982,25 @@ func (daemon *Daemon) sendStatusEmail() {
 		message.WriteString("No currently know vulnerabilities\n")
 	}
 	msg := message.String()
-	daemon.sendEmail(&subject, &msg)
+	daemon.notifyAll(daemon.ctx, Event{Kind: EventStatus, Subject: subject, Body: msg})
 	daemon.scanCount = 0
-	log.Debug("Sent Status Email")
+	daemon.Logger.Debug("Sent Status Email")
 }
 
 func createAndLoadFile(name string) *os.File {
 	if _, err := os.Stat(name); os.IsNotExist(err) {
 		file, err := os.Create(name)
 		if err != nil {
-			log.Fatalf("Error with os.Create(): %v", err)
+			log.WithError(err).Fatal("Error with os.Create()")
 		}
-		log.Debug("Created file %v", name)
+		log.WithField("file", name).Debug("Created file")
 		return file
 	} else {
 		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
 		if err != nil {
-			log.Fatalf("Error with os.Open(): %v", err)
+			log.WithError(err).Fatal("Error with os.Open()")
 		}
-		log.Debug("Opened existing file %v", name)
+		log.WithField("file", name).Debug("Opened existing file")
 		return file
 	}
 }
@@ -792,23 +1009,40 @@ func (daemon *Daemon) CreateErrorEmailer() {
 	daemon.errorChan = make(chan string, 10)
 	go func() {
 		for message := range daemon.errorChan {
-			var subject = "An error occured with Sploit:"
-			daemon.sendEmail(&subject, &message)
+			isLeader, err := daemon.coordinator.IsLeader(daemon.ctx)
+			if err != nil {
+				daemon.Logger.WithError(err).Warn("Coordinator unavailable, skipping error notification on this node")
+				continue
+			}
+			if !isLeader {
+				daemon.Logger.Debug("Not the elected leader, skipping error notification")
+				continue
+			}
+			daemon.notifyAll(daemon.ctx, Event{Kind: EventError, Subject: "An error occured with Sploit:", Body: message})
 		}
 	}()
 }
 
-var log = logging.MustGetLogger("sploit")
+// log is a bootstrap logger used before SetupLogging has configured
+// daemon.Logger (e.g. during flag/config parsing); SetupLogging repoints it
+// at daemon.Logger once one exists.
+var log = logrus.New()
 
 func main() {
 	// Do it already
 	daemon := &Daemon{}
 	daemon.knownModules = map[string][]string{}
+	daemon.activeConsoles = map[string]bool{}
+	daemon.scanEntries = map[string]cron.EntryID{}
 	daemon.LoadFlags()
 	daemon.LoadSploitYaml()
 	daemon.SetupLogging()
 	log.Info("Starting Sploitinator")
-	daemon.waitGroup = *new(sync.WaitGroup) // supply a mechanism for staying running
+	daemon.waitGroup = *new(sync.WaitGroup) // tracks in-flight scans/updates, drained on shutdown
+	daemon.ctx, daemon.cancel = context.WithCancel(context.Background())
+	daemon.shutdownComplete = make(chan struct{})
+	daemon.notifiers = daemon.buildNotifiers()
+	daemon.coordinator = daemon.buildCoordinator()
 	daemon.CreateInterruptChannel()
 	daemon.CreateErrorEmailer()
 	daemon.LoadServicesYaml()
@@ -825,5 +1059,5 @@ func main() {
 	daemon.CreateWebserver()
 	daemon.CreateUpdaterNotifier()
 	daemon.CreateStatusNotifier()
-	daemon.waitGroup.Wait() // Stay running until wg.Done() is called
+	<-daemon.shutdownComplete // Stay running until shutdown has fully completed
 }